@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Method maps http methods to different handlers. If no match is found a 405
@@ -22,6 +23,14 @@ func (route Method) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Accept", strings.Join(route.allowedMethods(), ","))
+
+	if method != "OPTIONS" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (route Method) allowedMethods() []string {
 	ks := []string{}
 	for k := range route {
 		ks = append(ks, k)
@@ -29,10 +38,107 @@ func (route Method) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ks = append(ks, "OPTIONS")
 	sort.Strings(ks)
 
-	w.Header().Set("Accept", strings.Join(ks, ","))
+	return ks
+}
+
+// AllowOrigins builds a CORS AllowOrigin predicate from a static list of
+// origins. A "*" entry allows any origin.
+func AllowOrigins(origins ...string) func(string) bool {
+	set := map[string]bool{}
+	for _, o := range origins {
+		set[o] = true
+	}
 
-	if method != "OPTIONS" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	return func(origin string) bool {
+		return set["*"] || set[origin]
+	}
+}
+
+// CORS wraps a Method router, answering cross-origin preflight requests and
+// annotating simple/actual requests with the appropriate Access-Control-*
+// headers before dispatching to the matched method handler. Requests whose
+// Origin header is missing, or not allowed by AllowOrigin, are passed
+// straight through to Method untouched.
+type CORS struct {
+	Method Method
+
+	// AllowOrigin decides whether the request's Origin header is allowed.
+	// Use AllowOrigins to build one from a static list, or supply a
+	// predicate of your own.
+	AllowOrigin func(origin string) bool
+
+	// AllowHeaders lists the request headers a preflight may ask for. A
+	// single "*" entry echoes back whatever Access-Control-Request-Headers
+	// asked for.
+	AllowHeaders []string
+
+	// ExposeHeaders lists the response headers exposed to the requesting
+	// page's script.
+	ExposeHeaders []string
+
+	// AllowCredentials, if true, permits the request to be made with
+	// credentials (cookies, HTTP authentication) included.
+	AllowCredentials bool
+
+	// MaxAge, if positive, is how long a preflight response may be cached
+	// for by the browser.
+	MaxAge time.Duration
+}
+
+func (c CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" || c.AllowOrigin == nil || !c.AllowOrigin(origin) {
+		c.Method.ServeHTTP(w, r)
+		return
+	}
+
+	addVary(w, "Origin")
+
+	if strings.ToUpper(r.Method) == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		c.servePreflight(w, r, origin)
+		return
+	}
+
+	c.writeOriginHeaders(w, origin)
+	c.Method.ServeHTTP(w, r)
+}
+
+func (c CORS) servePreflight(w http.ResponseWriter, r *http.Request, origin string) {
+	requestedMethod := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+
+	if _, ok := c.Method[requestedMethod]; !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	c.writeOriginHeaders(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.Method.allowedMethods(), ","))
+
+	if len(c.AllowHeaders) == 1 && c.AllowHeaders[0] == "*" {
+		if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+	} else if len(c.AllowHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ","))
+	}
+
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c CORS) writeOriginHeaders(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if len(c.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ","))
 	}
 }
 
@@ -44,6 +150,8 @@ func (route Method) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type ContentType map[string]http.Handler
 
 func (route ContentType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w, "Content-Type")
+
 	contentType := r.Header.Get("Content-Type")
 
 	mediaType, _, err := mime.ParseMediaType(contentType)
@@ -91,62 +199,315 @@ func (route ContentType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // match the acceptable content type with the highest requested quality and
 // greatest specificity. A fallback of */* can be specified which will always
 // match if no others do, otherwise a 406 Not Acceptable response is returned.
+//
+// When a concrete key (such as application/json) is matched, ServeHTTP sets
+// the response's Content-Type header to that key before calling the handler;
+// wildcard keys (application/*, */*) never set it, since there is nothing
+// concrete to guess from. Call WithoutContentType to opt out of this if the
+// handler wants to set Content-Type itself.
 type Accept map[string]http.Handler
 
 func (route Accept) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	accept := r.Header.Get("Accept")
+	route.serveHTTP(w, r, true)
+}
 
-	contentTypes := parseContentTypeList(accept)
+// WithoutContentType returns a handler that routes identically to route but
+// never sets the response's Content-Type header itself.
+func (route Accept) WithoutContentType() http.Handler {
+	return acceptWithoutContentType{route}
+}
 
-	sort.Sort(byQuality(contentTypes))
+type acceptWithoutContentType struct {
+	route Accept
+}
 
-	for _, ct := range contentTypes {
-		for rout, handler := range route {
-			rsplit := strings.Split(rout, "/")
+func (a acceptWithoutContentType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.route.serveHTTP(w, r, false)
+}
 
-			// 1. Check for exact match
-			// 2. Check for subtype match
-			// 3. Check for wildcard
-			//
-			// Since the contentTypes are ordered with wildcards below specifics we
-			// can check in this order with no problems.
-			if ct.Type == rsplit[0] && ct.Subtype == rsplit[1] ||
-				ct.Type == rsplit[0] && ct.Subtype == "*" ||
-				ct.Type == "*" && ct.Subtype == "*" {
+func (route Accept) serveHTTP(w http.ResponseWriter, r *http.Request, setContentType bool) {
+	addVary(w, "Accept")
 
-				handler.ServeHTTP(w, r)
-				return
-			}
+	clauses := parseContentTypeList(r.Header.Get("Accept"))
+
+	// An absent or empty Accept header means only an explicit */* handler
+	// applies; there is nothing to rank.
+	if len(clauses) == 0 {
+		if handler, ok := route["*/*"]; ok {
+			handler.ServeHTTP(w, r)
+			return
 		}
+
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
 	}
 
-	if handler, ok := route["*/*"]; ok {
-		handler.ServeHTTP(w, r)
+	routs := make([]string, 0, len(route))
+	for rout := range route {
+		routs = append(routs, rout)
+	}
+	sort.Strings(routs)
+
+	var (
+		found       bool
+		bestRout    string
+		bestClause  clause
+		bestQuality float32
+		bestScore   float64
+	)
+
+	for _, rout := range routs {
+		// */* is a catch-all fallback, not a representation in its own
+		// right: every clause scores identically against it, so it can't
+		// take part in quality-based ranking without an arbitrary
+		// tie-break. It's only used below once nothing else matches.
+		if rout == "*/*" {
+			continue
+		}
+
+		rt, ok := parseContentType(rout)
+		if !ok {
+			continue
+		}
+
+		matched, quality, score := bestClauseFor(rt, clauses)
+		if !matched {
+			continue
+		}
+
+		if !found || quality > bestQuality || quality == bestQuality && score > bestScore {
+			found = true
+			bestRout = rout
+			bestClause = rt
+			bestQuality = quality
+			bestScore = score
+		}
+	}
+
+	if !found {
+		if handler, ok := route["*/*"]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
 
+	if setContentType && bestClause.Type != "*" && bestClause.Subtype != "*" {
+		w.Header().Set("Content-Type", bestRout)
+	}
+
+	route[bestRout].ServeHTTP(w, r)
+}
+
+// bestClauseFor finds the most specific of clauses that applies to rout,
+// per RFC 7231 §5.3.2: a media range whose parameters are a better match for
+// rout takes precedence over a less specific one, regardless of relative
+// quality. Its quality is what determines rout's standing against other
+// routes.
+func bestClauseFor(rt clause, clauses []clause) (matched bool, quality float32, score float64) {
+	bestScore := -1.0
+
+	for _, ct := range clauses {
+		if ct.Quality <= 0 {
+			continue
+		}
+
+		s, ok := mediaRangeScore(ct, rt)
+		if !ok {
+			continue
+		}
+
+		if !matched || s > bestScore {
+			matched = true
+			bestScore = s
+			quality = ct.Quality
+		}
+	}
+
+	return matched, quality, bestScore
+}
+
+// mediaRangeScore reports how specifically the media range ct matches rt,
+// and whether it matches at all. A concrete type or subtype match scores
+// higher than a wildcard one, and every parameter the two have in common
+// (with an equal value) adds to the score, so that more specific ranges such
+// as application/vnd.api+json;version=2 outscore application/json. A
+// parameter present on both sides with different values is a hard conflict;
+// a parameter present on only one side (such as a stray charset a client
+// tacked on) is not, so it doesn't stop an otherwise-matching route from
+// being selected.
+func mediaRangeScore(ct, rt clause) (score float64, ok bool) {
+	if ct.Type != rt.Type && ct.Type != "*" && rt.Type != "*" {
+		return 0, false
+	}
+
+	if ct.Subtype != rt.Subtype && ct.Subtype != "*" && rt.Subtype != "*" {
+		return 0, false
+	}
+
+	shared := 0
+	for k, v := range ct.Params {
+		if rv, ok := rt.Params[k]; ok {
+			if rv != v {
+				return 0, false
+			}
+
+			shared++
+		}
+	}
+
+	if ct.Type == rt.Type && rt.Type != "*" {
+		score += 2
+	}
+
+	if ct.Subtype == rt.Subtype && rt.Subtype != "*" {
+		score += 2
+	}
+
+	score += float64(shared)
+
+	return score, true
+}
+
+// AcceptEncoding maps content-coding tokens (gzip, deflate, br, identity, *)
+// to different handlers based on the request's Accept-Encoding header. The
+// matched handler is expected to set Content-Encoding itself; ServeHTTP only
+// chooses which handler to call. A registered identity handler is used when
+// the client has no preference, and * can be registered as a catch-all for
+// any coding not otherwise listed. identity;q=0 and *;q=0 are honoured as
+// hard rejections, otherwise a 406 Not Acceptable response is returned.
+type AcceptEncoding map[string]http.Handler
+
+func (route AcceptEncoding) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w, "Accept-Encoding")
+
+	encodings := parseEncodingList(r.Header.Get("Accept-Encoding"))
+	sort.Sort(byEncodingQuality(encodings))
+
+	mentioned := map[string]bool{}
+	identityForbidden := false
+	wildcardForbidden := false
+	for _, e := range encodings {
+		mentioned[e.Coding] = true
+		if e.Coding == "identity" && e.Quality == 0 {
+			identityForbidden = true
+		}
+		if e.Coding == "*" && e.Quality == 0 {
+			wildcardForbidden = true
+		}
+	}
+
+	for _, e := range encodings {
+		if e.Quality == 0 {
+			continue
+		}
+
+		if handler, ok := route[e.Coding]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	if !identityForbidden && !mentioned["identity"] {
+		if handler, ok := route["identity"]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	if !wildcardForbidden && !mentioned["*"] {
+		if handler, ok := route["*"]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusNotAcceptable)
 }
 
-type byQuality []clause
+type encodingClause struct {
+	Coding  string
+	Quality float32
+}
+
+type byEncodingQuality []encodingClause
 
-func (cs byQuality) Len() int {
-	return len(cs)
+func (es byEncodingQuality) Len() int {
+	return len(es)
 }
 
-func (cs byQuality) Swap(i, j int) {
-	cs[i], cs[j] = cs[j], cs[i]
+func (es byEncodingQuality) Swap(i, j int) {
+	es[i], es[j] = es[j], es[i]
 }
 
-func (cs byQuality) Less(i, j int) bool {
-	return cs[i].Quality > cs[j].Quality ||
-		cs[i].Type != "*" && cs[j].Type == "*" ||
-		cs[i].Subtype != "*" && cs[j].Subtype == "*"
+func (es byEncodingQuality) Less(i, j int) bool {
+	return es[i].Quality > es[j].Quality
+}
+
+func parseEncodingList(s string) []encodingClause {
+	s = strings.Trim(s, " ")
+	if len(s) == 0 {
+		return []encodingClause{}
+	}
+
+	parts := strings.Split(s, ",")
+	ecs := make([]encodingClause, 0, len(parts))
+	for _, part := range parts {
+		ec, ok := parseEncoding(part)
+		if !ok {
+			continue
+		}
+
+		ecs = append(ecs, ec)
+	}
+
+	return ecs
+}
+
+func parseEncoding(s string) (encodingClause, bool) {
+	fields := strings.Split(s, ";")
+
+	coding := strings.ToLower(strings.TrimSpace(fields[0]))
+	if coding == "" {
+		return encodingClause{}, false
+	}
+
+	q := float32(1.0)
+	for _, field := range fields[1:] {
+		field = strings.TrimSpace(field)
+		if !strings.HasPrefix(field, "q=") {
+			continue
+		}
+
+		qf, err := strconv.ParseFloat(strings.TrimPrefix(field, "q="), 32)
+		if err != nil {
+			return encodingClause{}, false
+		}
+
+		q = float32(qf)
+	}
+
+	return encodingClause{coding, q}, true
+}
+
+// addVary appends value to the response's Vary header, unless it is already
+// present.
+func addVary(w http.ResponseWriter, value string) {
+	for _, v := range w.Header().Values("Vary") {
+		if v == value {
+			return
+		}
+	}
+
+	w.Header().Add("Vary", value)
 }
 
 type clause struct {
 	Type    string
 	Subtype string
+	Params  map[string]string
 	Quality float32
 }
 
@@ -183,6 +544,7 @@ func parseContentType(s string) (clause, bool) {
 		if err != nil {
 			return clause{}, false
 		}
+		delete(params, "q")
 	}
 
 	mediaTypeParts := strings.Split(mediaType, "/")
@@ -190,5 +552,126 @@ func parseContentType(s string) (clause, bool) {
 		return clause{}, false
 	}
 
-	return clause{mediaTypeParts[0], mediaTypeParts[1], float32(q)}, true
+	return clause{mediaTypeParts[0], mediaTypeParts[1], params, float32(q)}, true
+}
+
+// AcceptLanguage maps language tags to different handlers based on the
+// request's Accept-Language header, using the "Lookup" filtering scheme from
+// RFC 4647 §3.4: each requested tag is tried in descending order of quality,
+// progressively truncating subtags from the right until a registered key
+// matches (so a request for en-US-x-lvariant-posix matches a handler
+// registered under en-US, then en). Matching is case-insensitive. A
+// registered * key acts as a fallback, otherwise a 406 Not Acceptable
+// response is returned.
+type AcceptLanguage map[string]http.Handler
+
+func (route AcceptLanguage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w, "Accept-Language")
+
+	languages := parseLanguageList(r.Header.Get("Accept-Language"))
+	sort.Stable(byLanguageQuality(languages))
+
+	for _, l := range languages {
+		if l.Quality == 0 {
+			continue
+		}
+
+		for tag := l.Tag; tag != ""; tag = truncateTag(tag) {
+			if handler, ok := lookupLanguage(route, tag); ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+	}
+
+	if handler, ok := route["*"]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotAcceptable)
+}
+
+func truncateTag(tag string) string {
+	i := strings.LastIndex(tag, "-")
+	if i == -1 {
+		return ""
+	}
+
+	return tag[:i]
+}
+
+func lookupLanguage(route AcceptLanguage, tag string) (http.Handler, bool) {
+	for k, v := range route {
+		if strings.EqualFold(k, tag) {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+type languageClause struct {
+	Tag     string
+	Quality float32
+}
+
+type byLanguageQuality []languageClause
+
+func (ls byLanguageQuality) Len() int {
+	return len(ls)
+}
+
+func (ls byLanguageQuality) Swap(i, j int) {
+	ls[i], ls[j] = ls[j], ls[i]
+}
+
+func (ls byLanguageQuality) Less(i, j int) bool {
+	return ls[i].Quality > ls[j].Quality
+}
+
+func parseLanguageList(s string) []languageClause {
+	s = strings.Trim(s, " ")
+	if len(s) == 0 {
+		return []languageClause{}
+	}
+
+	parts := strings.Split(s, ",")
+	lcs := make([]languageClause, 0, len(parts))
+	for _, part := range parts {
+		lc, ok := parseLanguage(part)
+		if !ok {
+			continue
+		}
+
+		lcs = append(lcs, lc)
+	}
+
+	return lcs
+}
+
+func parseLanguage(s string) (languageClause, bool) {
+	fields := strings.Split(s, ";")
+
+	tag := strings.TrimSpace(fields[0])
+	if tag == "" {
+		return languageClause{}, false
+	}
+
+	q := float32(1.0)
+	for _, field := range fields[1:] {
+		field = strings.TrimSpace(field)
+		if !strings.HasPrefix(field, "q=") {
+			continue
+		}
+
+		qf, err := strconv.ParseFloat(strings.TrimPrefix(field, "q="), 32)
+		if err != nil {
+			return languageClause{}, false
+		}
+
+		q = float32(qf)
+	}
+
+	return languageClause{tag, q}, true
 }
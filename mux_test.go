@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"hawx.me/code/assert"
 )
@@ -141,6 +142,144 @@ func TestMethodRoutingCanOverrideOptions(t *testing.T) {
 	assert.Equal(t, "OPTIONS, received", string(body))
 }
 
+// CORS
+
+func makeRequestWithHeaders(method, url string, headers map[string]string) (res *http.Response, body string, err error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(""))
+	if err != nil {
+		return
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+
+	bodyb, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	body = string(bodyb)
+
+	return
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	ts := httptest.NewServer(CORS{
+		Method: Method{
+			"GET": writeHandler("GET, received"),
+		},
+		AllowOrigin: AllowOrigins("https://example.com"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithHeaders("GET", ts.URL, map[string]string{
+		"Origin": "https://example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "GET, received", body)
+	assert.Equal(t, "https://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSSimpleRequestWithDisallowedOrigin(t *testing.T) {
+	ts := httptest.NewServer(CORS{
+		Method: Method{
+			"GET": writeHandler("GET, received"),
+		},
+		AllowOrigin: AllowOrigins("https://example.com"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithHeaders("GET", ts.URL, map[string]string{
+		"Origin": "https://evil.example",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "GET, received", body)
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSPreflight(t *testing.T) {
+	ts := httptest.NewServer(CORS{
+		Method: Method{
+			"GET":  writeHandler("GET, received"),
+			"POST": writeHandler("POST, received"),
+		},
+		AllowOrigin:  AllowOrigins("https://example.com"),
+		AllowHeaders: []string{"Content-Type"},
+		MaxAge:       10 * time.Minute,
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithHeaders("OPTIONS", ts.URL, map[string]string{
+		"Origin":                         "https://example.com",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "Content-Type",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Equal(t, "", body)
+	assert.Equal(t, "https://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,OPTIONS,POST", res.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", res.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", res.Header.Get("Access-Control-Max-Age"))
+}
+
+func TestCORSPreflightWithDisallowedMethod(t *testing.T) {
+	ts := httptest.NewServer(CORS{
+		Method: Method{
+			"GET": writeHandler("GET, received"),
+		},
+		AllowOrigin: AllowOrigins("https://example.com"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithHeaders("OPTIONS", ts.URL, map[string]string{
+		"Origin":                        "https://example.com",
+		"Access-Control-Request-Method": "DELETE",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 403, res.StatusCode)
+	assert.Equal(t, "", body)
+}
+
+func TestCORSPreflightWithWildcardHeaders(t *testing.T) {
+	ts := httptest.NewServer(CORS{
+		Method: Method{
+			"GET": writeHandler("GET, received"),
+		},
+		AllowOrigin:  AllowOrigins("https://example.com"),
+		AllowHeaders: []string{"*"},
+	})
+	defer ts.Close()
+
+	res, _, err := makeRequestWithHeaders("OPTIONS", ts.URL, map[string]string{
+		"Origin":                         "https://example.com",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "X-Custom-Header",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Equal(t, "X-Custom-Header", res.Header.Get("Access-Control-Allow-Headers"))
+}
+
 // ContentType
 
 func makeRequestWithType(method, url, contentType string) (res *http.Response, body string, err error) {
@@ -376,3 +515,430 @@ func TestAcceptRoutingWithBadMediaType(t *testing.T) {
 	assert.Equal(t, 406, res.StatusCode)
 	assert.Equal(t, "", string(body))
 }
+
+// AcceptEncoding
+
+func makeRequestWithAcceptEncoding(method, url, acceptEncoding string) (res *http.Response, body string, err error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(""))
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if err != nil {
+		return
+	}
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+
+	bodyb, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	body = string(bodyb)
+
+	return
+}
+
+func TestAcceptEncodingRouting(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip":     writeHandler("cool gzip"),
+		"identity": writeHandler("cool identity"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool gzip", string(body))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+}
+
+func TestAcceptEncodingRoutingWithWeightedList(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip":    writeHandler("cool gzip"),
+		"deflate": writeHandler("cool deflate"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "gzip;q=0.5,deflate;q=0.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool deflate", string(body))
+}
+
+func TestAcceptEncodingRoutingFallsBackToIdentity(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip":     writeHandler("cool gzip"),
+		"identity": writeHandler("cool identity"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "br")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool identity", string(body))
+}
+
+func TestAcceptEncodingRoutingFallsBackToWildcard(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip": writeHandler("cool gzip"),
+		"*":    writeHandler("cool wildcard"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "br")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool wildcard", string(body))
+}
+
+func TestAcceptEncodingRoutingWithIdentityDisallowed(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip":     writeHandler("cool gzip"),
+		"identity": writeHandler("cool identity"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "identity;q=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 406, res.StatusCode)
+	assert.Equal(t, "", string(body))
+}
+
+func TestAcceptRoutingByParameterSpecificity(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/vnd.api+json;version=1": writeHandler("v1"),
+		"application/vnd.api+json;version=2": writeHandler("v2"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAccept("GET", ts.URL, "application/vnd.api+json;version=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "v2", string(body))
+}
+
+func TestAcceptRoutingIgnoresUnrelatedParameters(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/json": writeHandler("cool json"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAccept("GET", ts.URL, "application/json;charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool json", string(body))
+}
+
+func TestAcceptRoutingPrefersExactSubtypeOverWildcard(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"text/html": writeHandler("cool html"),
+		"text/*":    writeHandler("cool text"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAccept("GET", ts.URL, "text/*;q=1,text/html;q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool html", string(body))
+}
+
+// This is the classic example from RFC 7231 §5.3.2: text/html;level=1 has no
+// explicit q (so defaults to 1) but is the most specific range that applies
+// to it, which must win over text/html;q=0.7 applying more generally.
+func TestAcceptRoutingRFCSpecificityExample(t *testing.T) {
+	const accept = "text/*;q=0.3, text/html;q=0.7, text/html;level=1, text/html;level=2;q=0.4, */*;q=0.5"
+
+	ts := httptest.NewServer(Accept{
+		"text/html;level=1": writeHandler("level1"),
+		"text/html;level=2": writeHandler("level2"),
+		"text/html":         writeHandler("html"),
+		"text/plain":        writeHandler("plain"),
+		"image/jpeg":        writeHandler("jpeg"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAccept("GET", ts.URL, accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "level1", string(body))
+}
+
+// text/html;level=2 matches only the text/html;level=2;q=0.4 range, which is
+// a worse quality than the text/html;q=0.7 range that text/html (with no
+// level parameter) matches instead.
+func TestAcceptRoutingRFCSpecificityExampleQualityOverSpecificity(t *testing.T) {
+	const accept = "text/html;q=0.7, text/html;level=2;q=0.4"
+
+	ts := httptest.NewServer(Accept{
+		"text/html":         writeHandler("html"),
+		"text/html;level=2": writeHandler("level2"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAccept("GET", ts.URL, accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "html", string(body))
+}
+
+func TestAcceptRoutingSetsContentType(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/xml":  writeHandler("cool xml"),
+		"application/json": writeHandler("cool json"),
+	})
+	defer ts.Close()
+
+	res, _, err := makeRequestWithAccept("GET", ts.URL, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept", res.Header.Get("Vary"))
+}
+
+func TestAcceptRoutingWildcardDoesNotSetContentType(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/xml": writeHandler("cool xml"),
+		"*/*":             writeHandler("cool wildcard"),
+	})
+	defer ts.Close()
+
+	res, _, err := makeRequestWithAccept("GET", ts.URL, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	if ct := res.Header.Get("Content-Type"); ct == "application/xml" || ct == "*/*" {
+		t.Errorf("expected Content-Type not to be guessed from a wildcard route, got %q", ct)
+	}
+}
+
+func TestAcceptRoutingPrefersConcreteMatchOverWildcardRoute(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/xml":  writeHandler("cool xml"),
+		"application/json": writeHandler("cool json"),
+		"*/*":              writeHandler("cool wildcard"),
+	})
+	defer ts.Close()
+
+	// The client's */* carries the highest quality, but */* is a fallback
+	// route, not a ranked representation, so the concrete application/json
+	// match should still win.
+	res, body, err := makeRequestWithAccept("GET", ts.URL, "application/xml;q=0.3,application/json;q=0.3,*/*;q=0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool json", string(body))
+}
+
+func TestAcceptRoutingWithoutContentType(t *testing.T) {
+	ts := httptest.NewServer(Accept{
+		"application/json": writeHandler("cool json"),
+	}.WithoutContentType())
+	defer ts.Close()
+
+	res, _, err := makeRequestWithAccept("GET", ts.URL, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	if ct := res.Header.Get("Content-Type"); ct == "application/json" {
+		t.Errorf("expected WithoutContentType to leave Content-Type unset, got %q", ct)
+	}
+}
+
+func TestContentTypeRoutingSetsVary(t *testing.T) {
+	ts := httptest.NewServer(ContentType{
+		"application/json": writeHandler("cool json"),
+	})
+	defer ts.Close()
+
+	res, _, err := makeRequestWithType("GET", ts.URL, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "Content-Type", res.Header.Get("Vary"))
+}
+
+func TestAcceptEncodingRoutingWithWildcardDisallowed(t *testing.T) {
+	ts := httptest.NewServer(AcceptEncoding{
+		"gzip": writeHandler("cool gzip"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptEncoding("GET", ts.URL, "br,*;q=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 406, res.StatusCode)
+	assert.Equal(t, "", string(body))
+}
+
+// AcceptLanguage
+
+func makeRequestWithAcceptLanguage(method, url, acceptLanguage string) (res *http.Response, body string, err error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(""))
+	req.Header.Set("Accept-Language", acceptLanguage)
+	if err != nil {
+		return
+	}
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+
+	bodyb, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	body = string(bodyb)
+
+	return
+}
+
+func TestAcceptLanguageRouting(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en": writeHandler("cool english"),
+		"fr": writeHandler("cool french"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool french", string(body))
+	assert.Equal(t, "Accept-Language", res.Header.Get("Vary"))
+}
+
+func TestAcceptLanguageRoutingWithWeightedList(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en": writeHandler("cool english"),
+		"fr": writeHandler("cool french"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "fr;q=0.5,en;q=0.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool english", string(body))
+}
+
+func TestAcceptLanguageRoutingTruncatesSubtags(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en-US": writeHandler("cool en-US"),
+		"en":    writeHandler("cool en"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "en-US-x-lvariant-posix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool en-US", string(body))
+}
+
+func TestAcceptLanguageRoutingTruncatesToMoreGeneralTag(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en": writeHandler("cool en"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "en-GB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool en", string(body))
+}
+
+func TestAcceptLanguageRoutingIsCaseInsensitive(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en-US": writeHandler("cool en-US"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "EN-us")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool en-US", string(body))
+}
+
+func TestAcceptLanguageRoutingFallsBackToWildcard(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en": writeHandler("cool en"),
+		"*":  writeHandler("cool wildcard"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "cool wildcard", string(body))
+}
+
+func TestAcceptLanguageRoutingWithUnknown(t *testing.T) {
+	ts := httptest.NewServer(AcceptLanguage{
+		"en": writeHandler("cool en"),
+	})
+	defer ts.Close()
+
+	res, body, err := makeRequestWithAcceptLanguage("GET", ts.URL, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 406, res.StatusCode)
+	assert.Equal(t, "", string(body))
+}
@@ -0,0 +1,267 @@
+package strict
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// MaxMultipartMemory bounds the size of any single file part the Multipart
+// codec will read, mirroring the default used by
+// (*http.Request).ParseMultipartForm. A file part larger than this is
+// rejected rather than spilled to disk.
+var MaxMultipartMemory int64 = 32 << 20 // 32MB
+
+// File is the value bound to a struct field tagged `file:"..."` by the
+// Multipart codec, and written back out by it when encoding.
+type File struct {
+	Filename string
+	Header   textproto.MIMEHeader
+	Content  []byte
+}
+
+// Form decodes and encodes application/x-www-form-urlencoded bodies using a
+// struct's `form:"..."` tags, converting to and from the field's type.
+var Form = codec{
+	Decode: func(r *http.Request, v interface{}) error {
+		defer r.Body.Close()
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		return bindValues(r.Form, v)
+	},
+	Encode: func(w http.ResponseWriter, v interface{}) error {
+		values, err := formValues(v)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err = io.WriteString(w, values.Encode())
+		return err
+	},
+}
+
+// Multipart decodes multipart/form-data bodies into a struct using
+// `form:"..."` tags for regular fields and `file:"..."` tags for uploaded
+// files (bound as a File), rejecting any file part larger than
+// MaxMultipartMemory. Encoding writes a struct back out the same way, with
+// File fields written as file parts.
+var Multipart = codec{
+	Decode: decodeMultipart,
+	Encode: encodeMultipart,
+}
+
+func decodeMultipart(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	boundary, ok := params["boundary"]
+	if !ok {
+		return fmt.Errorf("strict: multipart request has no boundary")
+	}
+
+	mr := multipart.NewReader(r.Body, boundary)
+	values := url.Values{}
+	files := map[string]File{}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+
+		if filename := part.FileName(); filename != "" {
+			content, err := ioutil.ReadAll(io.LimitReader(part, MaxMultipartMemory+1))
+			if err != nil {
+				return err
+			}
+			if int64(len(content)) > MaxMultipartMemory {
+				return fmt.Errorf("strict: file %q exceeds %d bytes", name, MaxMultipartMemory)
+			}
+
+			files[name] = File{Filename: filename, Header: part.Header, Content: content}
+			continue
+		}
+
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		values.Add(name, string(content))
+	}
+
+	if err := bindValues(values, v); err != nil {
+		return err
+	}
+	return bindFiles(files, v)
+}
+
+func encodeMultipart(w http.ResponseWriter, v interface{}) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("strict: multipart encode needs a struct, got %T", v)
+	}
+
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+
+		if name := field.Tag.Get("form"); name != "" {
+			if err := mw.WriteField(name, fmt.Sprint(rv.Field(i).Interface())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if name := field.Tag.Get("file"); name != "" {
+			file, ok := rv.Field(i).Interface().(File)
+			if !ok {
+				continue
+			}
+
+			part, err := mw.CreateFormFile(name, file.Filename)
+			if err != nil {
+				return err
+			}
+			if _, err := part.Write(file.Content); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", mw.FormDataContentType())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// bindValues sets the fields of v (a pointer to struct) tagged `form:"..."`
+// from values, converting each to the field's type.
+func bindValues(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("strict: form decode needs a pointer to struct, got %T", v)
+	}
+	rv = rv.Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		name := rv.Type().Field(i).Tag.Get("form")
+		if name == "" {
+			continue
+		}
+
+		raw := values.Get(name)
+		if raw == "" {
+			continue
+		}
+
+		if err := setValue(rv.Field(i), raw); err != nil {
+			return fmt.Errorf("strict: field %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	default:
+		return fmt.Errorf("strict: unsupported form field type %s", field.Kind())
+	}
+	return nil
+}
+
+// bindFiles sets the fields of v (a pointer to struct) tagged `file:"..."`
+// from files.
+func bindFiles(files map[string]File, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		name := rv.Type().Field(i).Tag.Get("file")
+		if name == "" {
+			continue
+		}
+
+		file, ok := files[name]
+		if !ok {
+			continue
+		}
+
+		if rv.Field(i).Type() != reflect.TypeOf(File{}) {
+			return fmt.Errorf("strict: field %q must be strict.File", name)
+		}
+
+		rv.Field(i).Set(reflect.ValueOf(file))
+	}
+
+	return nil
+}
+
+// formValues reads the fields of v tagged `form:"..."` into a url.Values,
+// for encoding as an application/x-www-form-urlencoded response.
+func formValues(v interface{}) (url.Values, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("strict: form encode needs a struct, got %T", v)
+	}
+
+	values := url.Values{}
+	for i := 0; i < rv.NumField(); i++ {
+		name := rv.Type().Field(i).Tag.Get("form")
+		if name == "" {
+			continue
+		}
+
+		values.Set(name, fmt.Sprint(rv.Field(i).Interface()))
+	}
+
+	return values, nil
+}
@@ -0,0 +1,196 @@
+// Package strict builds typed http.Handlers on top of mux.ContentType and
+// mux.Accept, so that request bodies are decoded and response bodies are
+// encoded according to content negotiation instead of by hand in every
+// handler.
+package strict
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+
+	"hawx.me/code/mux"
+)
+
+// Decoder reads the body of r into v, which is always a pointer to the
+// handler's input type.
+type Decoder func(r *http.Request, v interface{}) error
+
+// Encoder writes v, the handler's output value, to w. It is responsible for
+// setting any headers (such as Content-Type) the representation needs.
+type Encoder func(w http.ResponseWriter, v interface{}) error
+
+// Decoders is the set of Decoder built into the package, keyed by the
+// Content-Type they handle. Pass this to Handler, or a copy of it with
+// entries added or removed, to control which request bodies are accepted.
+var Decoders = map[string]Decoder{
+	"application/json":                  JSON.Decode,
+	"application/xml":                   XML.Decode,
+	"application/x-www-form-urlencoded": Form.Decode,
+	"multipart/form-data":               Multipart.Decode,
+}
+
+// Encoders is the set of Encoder built into the package, keyed by the
+// Content-Type they produce. Pass this to Handler, or a copy of it with
+// entries added or removed, to control which representations may be
+// returned.
+var Encoders = map[string]Encoder{
+	"application/json":                  JSON.Encode,
+	"application/xml":                   XML.Encode,
+	"application/x-www-form-urlencoded": Form.Encode,
+	"multipart/form-data":               Multipart.Encode,
+}
+
+// Error lets a handler function control the status code and body of an
+// error response, instead of the caller receiving a 500 with fn's err.Error()
+// as the encoded body.
+type Error struct {
+	Code int
+	Body interface{}
+}
+
+func (e Error) Error() string {
+	return http.StatusText(e.Code)
+}
+
+// errorBody is the body written for decode failures and for handler errors
+// that aren't an Error, so that internal error detail (filesystem paths,
+// driver errors, etc.) is never serialized back to the caller. Use Error to
+// return a deliberate, caller-facing message instead. It carries a tag for
+// every encoder the package advertises as supported (JSON, XML, the form
+// tag doubling as the multipart/form-data field name too), so an error
+// response round-trips through whichever one the client negotiated.
+type errorBody struct {
+	Message string `json:"message" xml:"message" form:"message"`
+}
+
+// Handler builds an http.Handler that:
+//
+//  1. picks a Decoder using the request's Content-Type, returning 415 if
+//     none of decoders matches (this is mux.ContentType's behaviour);
+//  2. decodes the request body into In, returning 400 if that fails;
+//  3. calls fn, returning the status code and body of an Error if fn
+//     returns one, or 500 otherwise;
+//  4. picks an Encoder using the request's Accept header, returning 406 if
+//     none of encoders matches (this is mux.Accept's behaviour), then
+//     encodes fn's Out result with it.
+func Handler[In, Out any](decoders map[string]Decoder, encoders map[string]Encoder, fn func(context.Context, In) (Out, error)) http.Handler {
+	route := mux.ContentType{}
+	accept := newResponseRouter(encoders)
+
+	for contentType, decoder := range decoders {
+		decoder := decoder
+
+		route[contentType] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var in In
+			if err := decoder(r, &in); err != nil {
+				writeResponse(w, r, accept, http.StatusBadRequest, errorBody{Message: "invalid request body"})
+				return
+			}
+
+			out, err := fn(r.Context(), in)
+			if err != nil {
+				if strictErr, ok := err.(Error); ok {
+					writeResponse(w, r, accept, strictErr.Code, strictErr.Body)
+					return
+				}
+
+				writeResponse(w, r, accept, http.StatusInternalServerError, errorBody{Message: "internal server error"})
+				return
+			}
+
+			writeResponse(w, r, accept, http.StatusOK, out)
+		})
+	}
+
+	return route
+}
+
+// responseBodyKey is the context key writeResponse uses to pass a response's
+// status code and body to the handlers built once by newResponseRouter.
+type responseBodyKey struct{}
+
+type responseBody struct {
+	code int
+	body interface{}
+}
+
+// newResponseRouter builds the mux.Accept router used to encode every
+// response a Handler writes, once per Handler call rather than once per
+// request. Each entry runs its Encoder into a buffer first, so headers the
+// Encoder sets (such as a Content-Type with a boundary parameter) reach the
+// client: writing the status code has to come after that, since it locks in
+// whatever headers are set so far.
+func newResponseRouter(encoders map[string]Encoder) mux.Accept {
+	accept := mux.Accept{}
+
+	for contentType, encoder := range encoders {
+		encoder := encoder
+
+		accept[contentType] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp := r.Context().Value(responseBodyKey{}).(responseBody)
+
+			buf := &bufferedResponseWriter{header: make(http.Header)}
+			encoder(buf, resp.body)
+
+			dst := w.Header()
+			for key, values := range buf.header {
+				dst[key] = values
+			}
+
+			w.WriteHeader(resp.code)
+			w.Write(buf.body.Bytes())
+		})
+	}
+
+	return accept
+}
+
+// bufferedResponseWriter collects the headers and body an Encoder writes
+// without sending anything to the client, so they can be applied to the
+// real ResponseWriter before its status code is written.
+type bufferedResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header { return b.header }
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponseWriter) WriteHeader(int) {}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, accept mux.Accept, code int, body interface{}) {
+	ctx := context.WithValue(r.Context(), responseBodyKey{}, responseBody{code, body})
+	accept.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// codec is an Encoder/Decoder pair for a single wire format.
+type codec struct {
+	Decode Decoder
+	Encode Encoder
+}
+
+// JSON decodes and encodes using encoding/json.
+var JSON = codec{
+	Decode: func(r *http.Request, v interface{}) error {
+		defer r.Body.Close()
+		return json.NewDecoder(r.Body).Decode(v)
+	},
+	Encode: func(w http.ResponseWriter, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+}
+
+// XML decodes and encodes using encoding/xml.
+var XML = codec{
+	Decode: func(r *http.Request, v interface{}) error {
+		defer r.Body.Close()
+		return xml.NewDecoder(r.Body).Decode(v)
+	},
+	Encode: func(w http.ResponseWriter, v interface{}) error {
+		return xml.NewEncoder(w).Encode(v)
+	},
+}
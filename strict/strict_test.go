@@ -0,0 +1,284 @@
+package strict
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+type greeting struct {
+	Name string `json:"name" xml:"name" form:"name"`
+}
+
+type reply struct {
+	Message string `json:"message" xml:"message" form:"message"`
+}
+
+func greet(ctx context.Context, in greeting) (reply, error) {
+	if in.Name == "" {
+		return reply{}, Error{Code: http.StatusUnprocessableEntity, Body: reply{Message: "name is required"}}
+	}
+
+	return reply{Message: "hello, " + in.Name}, nil
+}
+
+func TestHandlerDecodesAndEncodesJSON(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"name":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "{\"message\":\"hello, alice\"}\n", string(body))
+}
+
+func TestHandlerRejectsUnknownContentType(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`name=alice`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 415, res.StatusCode)
+}
+
+func TestHandlerDecodesAndEncodesForm(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`name=alice`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "message=hello%2C+alice", string(body))
+}
+
+func TestHandlerDecodesMultipartForm(t *testing.T) {
+	type upload struct {
+		Name   string `form:"name"`
+		Avatar File   `file:"avatar"`
+	}
+
+	greetWithAvatar := func(ctx context.Context, in upload) (reply, error) {
+		return reply{Message: "hello, " + in.Name + " (" + string(in.Avatar.Content) + ")"}, nil
+	}
+
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greetWithAvatar))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("name", "alice")
+	part, err := mw.CreateFormFile("avatar", "avatar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("a face"))
+	mw.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "{\"message\":\"hello, alice (a face)\"}\n", string(body))
+}
+
+func TestHandlerEncodesMultipartResponseWithBoundary(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"name":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "multipart/form-data")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "multipart/form-data", mediaType)
+	if params["boundary"] == "" {
+		t.Fatal("expected Content-Type to carry a boundary parameter")
+	}
+
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(part)
+
+	assert.Equal(t, "message", part.FormName())
+	assert.Equal(t, "hello, alice", string(body))
+}
+
+func TestHandlerRejectsUnacceptableResponse(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"name":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/plain")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 406, res.StatusCode)
+}
+
+func TestHandlerRejectsBadBody(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`not json`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "{\"message\":\"invalid request body\"}\n", string(body))
+}
+
+func TestHandlerEncodesErrorsAsForm(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`not json`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "message=invalid+request+body", string(body))
+}
+
+func TestHandlerHidesInternalErrorDetail(t *testing.T) {
+	boom := func(ctx context.Context, in greeting) (reply, error) {
+		return reply{}, errors.New("open /etc/shadow: permission denied")
+	}
+	ts := httptest.NewServer(Handler(Decoders, Encoders, boom))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"name":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 500, res.StatusCode)
+	assert.Equal(t, "{\"message\":\"internal server error\"}\n", string(body))
+}
+
+func TestHandlerReturnsStrictError(t *testing.T) {
+	ts := httptest.NewServer(Handler(Decoders, Encoders, greet))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"name":""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, 422, res.StatusCode)
+	assert.Equal(t, "{\"message\":\"name is required\"}\n", string(body))
+}